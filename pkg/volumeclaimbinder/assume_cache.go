@@ -0,0 +1,191 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+)
+
+// AssumeCache sits in front of a persistentVolumeOrderedIndex and lets a caller optimistically
+// mutate a PV (Assume) immediately after picking it in FindBestMatchForClaim, before the API
+// write that binds it has round-tripped through the informer reflector. Without this, two
+// rapid claim-add events can both call FindBestMatchForClaim, both see the same unbound PV as
+// the smallest match, and both bind to it before either write is observed.
+//
+// Modeled on the scheduler's volume binder assume cache.
+type AssumeCache struct {
+	mu sync.RWMutex
+
+	store *persistentVolumeOrderedIndex
+
+	// assumed holds, per PV name, the locally mutated object together with the
+	// resourceVersion it was assumed at.
+	assumed map[string]assumedPV
+}
+
+type assumedPV struct {
+	obj            *api.PersistentVolume
+	assumedVersion uint64
+
+	// prior is the object as it stood immediately before this Assume, i.e. what the informer
+	// had last observed. Restore writes this back; it is nil if the key didn't exist yet.
+	prior *api.PersistentVolume
+}
+
+// NewAssumeCache returns an AssumeCache backed by store.
+func NewAssumeCache(store *persistentVolumeOrderedIndex) *AssumeCache {
+	return &AssumeCache{
+		store:   store,
+		assumed: make(map[string]assumedPV),
+	}
+}
+
+// resourceVersion parses a PV's ResourceVersion into a comparable counter. An empty or
+// unparseable ResourceVersion (e.g. on an object that hasn't been through the API server yet)
+// is treated as 0, the lowest possible version.
+func resourceVersion(pv *api.PersistentVolume) uint64 {
+	if pv.ObjectMeta.ResourceVersion == "" {
+		return 0
+	}
+	version, err := strconv.ParseUint(pv.ObjectMeta.ResourceVersion, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// Assume records pv as the current state of its key ahead of the API write that produced it
+// landing back through the informer. A subsequent Find against the underlying index will see
+// pv's mutated ClaimRef immediately. The object previously held for this key (whatever the
+// informer last observed) is retained so Restore can revert to it.
+func (c *AssumeCache) Assume(pv *api.PersistentVolume) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, err := cache.MetaNamespaceKeyFunc(pv)
+	if err != nil {
+		return err
+	}
+
+	// If a prior Assume is already outstanding for this key, keep its prior rather than the
+	// object it assumed - that's the informer-observed state we'd ultimately revert to.
+	var prior *api.PersistentVolume
+	if existing, ok := c.assumed[key]; ok {
+		prior = existing.prior
+	} else if obj, exists, err := c.store.GetByKey(key); err != nil {
+		return err
+	} else if exists {
+		prior = obj.(*api.PersistentVolume)
+	}
+
+	c.assumed[key] = assumedPV{obj: pv, assumedVersion: resourceVersion(pv), prior: prior}
+	return c.store.Update(pv)
+}
+
+// Restore reverts key to whatever the informer last observed before the outstanding Assume,
+// discarding the locally assumed mutation. Callers use this when the API write backing an
+// Assume fails.
+func (c *AssumeCache) Restore(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	assumed, ok := c.assumed[key]
+	if !ok {
+		return nil
+	}
+	delete(c.assumed, key)
+
+	if assumed.prior == nil {
+		return c.store.Delete(assumed.obj)
+	}
+	return c.store.Update(assumed.prior)
+}
+
+// FindBestMatchForClaim delegates to the underlying index. Since Assume writes its mutation
+// straight into the index (see Assume), the result already reflects any outstanding assumed
+// binds without needing to consult c.assumed here.
+func (c *AssumeCache) FindBestMatchForClaim(claim *api.PersistentVolumeClaim) (*api.PersistentVolume, error) {
+	return c.store.FindBestMatchForClaim(claim)
+}
+
+// GetByKey returns the current view of the object for key: the assumed object if one is
+// outstanding, otherwise whatever the underlying index holds.
+func (c *AssumeCache) GetByKey(key string) (interface{}, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if assumed, ok := c.assumed[key]; ok {
+		return assumed.obj, true, nil
+	}
+	return c.store.GetByKey(key)
+}
+
+// Add handles an informer Add event for pv. If an assumed mutation is outstanding for pv's
+// key and the informer's copy is not newer than what was assumed, the event is dropped so it
+// doesn't clobber the assumed state with the stale pre-bind PV the reflector is still
+// catching up on.
+func (c *AssumeCache) Add(obj interface{}) error {
+	return c.observe(obj, c.store.Add)
+}
+
+// Update handles an informer Update event for pv, subject to the same staleness check as Add.
+func (c *AssumeCache) Update(obj interface{}) error {
+	return c.observe(obj, c.store.Update)
+}
+
+// Delete removes pv from both the assumed overlay and the underlying index.
+func (c *AssumeCache) Delete(obj interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return err
+	}
+	delete(c.assumed, key)
+	return c.store.Delete(obj)
+}
+
+func (c *AssumeCache) observe(obj interface{}, apply func(interface{}) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pv, ok := obj.(*api.PersistentVolume)
+	if !ok {
+		return fmt.Errorf("object is not a persistent volume: %v", obj)
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(pv)
+	if err != nil {
+		return err
+	}
+
+	if assumed, ok := c.assumed[key]; ok {
+		if resourceVersion(pv) <= assumed.assumedVersion {
+			// Stale: the reflector hasn't yet observed the write the Assume anticipated.
+			return nil
+		}
+		delete(c.assumed, key)
+	}
+
+	return apply(pv)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestAssumeMakesMutationImmediatelyVisible(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	pv.ObjectMeta.ResourceVersion = "5"
+	index.Add(pv)
+
+	assumeCache := NewAssumeCache(index)
+
+	bound := *pv
+	bound.Spec.ClaimRef = &api.ObjectReference{Name: "claim-1"}
+	if err := assumeCache.Assume(&bound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claim := makeClaim("5G", nil)
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected the assumed (now bound) pv to no longer match, got %v", match)
+	}
+}
+
+func TestStaleReflectorUpdateDoesNotClobberAssumedState(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	pv.ObjectMeta.ResourceVersion = "5"
+	index.Add(pv)
+
+	assumeCache := NewAssumeCache(index)
+
+	bound := *pv
+	bound.Spec.ClaimRef = &api.ObjectReference{Name: "claim-1"}
+	bound.ObjectMeta.ResourceVersion = "5"
+	if err := assumeCache.Assume(&bound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The reflector replays the pre-bind object at the same resourceVersion it was assumed
+	// at; this must not revert the bind.
+	stale := *pv
+	if err := assumeCache.Add(&stale); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj, exists, err := assumeCache.GetByKey("pv-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists {
+		t.Fatalf("expected pv-1 to exist")
+	}
+	if obj.(*api.PersistentVolume).Spec.ClaimRef == nil {
+		t.Errorf("expected the stale reflector event to be dropped, leaving the pv bound")
+	}
+}
+
+func TestNewerReflectorUpdateClearsAssumedState(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	pv.ObjectMeta.ResourceVersion = "5"
+	index.Add(pv)
+
+	assumeCache := NewAssumeCache(index)
+
+	bound := *pv
+	bound.Spec.ClaimRef = &api.ObjectReference{Name: "claim-1"}
+	if err := assumeCache.Assume(&bound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The reflector now observes the real API write, at a newer resourceVersion.
+	confirmed := bound
+	confirmed.ObjectMeta.ResourceVersion = "6"
+	if err := assumeCache.Update(&confirmed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj, exists, err := assumeCache.GetByKey("pv-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists {
+		t.Fatalf("expected pv-1 to exist")
+	}
+	if obj.(*api.PersistentVolume).ObjectMeta.ResourceVersion != "6" {
+		t.Errorf("expected the newer reflector event to replace the assumed object")
+	}
+}
+
+func TestRestoreRevertsFailedAssume(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	index.Add(pv)
+
+	assumeCache := NewAssumeCache(index)
+
+	bound := *pv
+	bound.Spec.ClaimRef = &api.ObjectReference{Name: "claim-1"}
+	if err := assumeCache.Assume(&bound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := assumeCache.Restore("pv-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claim := makeClaim("5G", nil)
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "pv-1" {
+		t.Errorf("expected Restore to make pv-1 available again, got %v", match)
+	}
+
+	stored, exists, err := index.GetByKey("pv-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists {
+		t.Fatalf("expected pv-1 to still exist in the index")
+	}
+	if stored.(*api.PersistentVolume).Spec.ClaimRef != nil {
+		t.Errorf("expected Restore to clear the ClaimRef set by the reverted Assume, got %+v", stored.(*api.PersistentVolume).Spec.ClaimRef)
+	}
+}
+
+func TestRestoreOfNeverAssumedKeyDeletesNothing(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	assumeCache := NewAssumeCache(index)
+
+	if err := assumeCache.Restore("never-assumed"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
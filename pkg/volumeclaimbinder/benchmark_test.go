@@ -0,0 +1,70 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// populateIndex adds n unbound PersistentVolumes, with capacities spread across a wide range,
+// to index.
+func populateIndex(index *persistentVolumeOrderedIndex, n int) {
+	for i := 0; i < n; i++ {
+		capacity := strconv.Itoa(1+i%1000) + "G"
+		index.Add(makeVolume("pv-"+strconv.Itoa(i), capacity, nil))
+	}
+}
+
+// BenchmarkFindBestMatchForClaim demonstrates that a single Find scales with the size of its
+// bucket's neighborhood around the requested capacity, not with the total number of volumes
+// in the bucket - the motivation for replacing the old sort-on-every-call ListByAccessModes.
+func BenchmarkFindBestMatchForClaim(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000, 100000} {
+		n := n
+		b.Run(fmt.Sprintf("%d_pvs", n), func(b *testing.B) {
+			index := NewPersistentVolumeOrderedIndex()
+			populateIndex(index, n)
+			claim := makeClaim("500G", nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := index.FindBestMatchForClaim(claim); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkAdd demonstrates that adding a volume to an already-populated index is O(log N)
+// rather than the O(N log N) a full resort would cost.
+func BenchmarkAdd(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000, 100000} {
+		n := n
+		b.Run(fmt.Sprintf("%d_pvs", n), func(b *testing.B) {
+			index := NewPersistentVolumeOrderedIndex()
+			populateIndex(index, n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Add(makeVolume(fmt.Sprintf("extra-%d", i), "500G", nil))
+			}
+		})
+	}
+}
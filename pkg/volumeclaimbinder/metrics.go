@@ -0,0 +1,94 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/metrics"
+)
+
+const metricsSubsystem = "volumeclaimbinder"
+
+var (
+	// volumeWastedBytes tracks how much of a bound PV's capacity went unused by the claim it
+	// was matched to (pv capacity - claim request), so operators can spot binds that are
+	// systematically over-allocating.
+	volumeWastedBytes = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "bind_wasted_bytes",
+			Help:      "Bytes of storage capacity left unused by a bind decision (pv capacity minus claim request), bucketed by access mode.",
+			Buckets:   metrics.ExponentialBuckets(1<<20, 4, 10), // 1MiB ... ~256GiB
+		},
+		[]string{"access_mode"},
+	)
+
+	// unmatchedClaimsTotal counts claims for which FindBestMatchForClaim found no candidate,
+	// labeled by access mode and storage class so a specific exhausted class stands out.
+	unmatchedClaimsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "unmatched_claims_total",
+			Help:      "Number of claims for which FindBestMatchForClaim found no matching persistent volume.",
+		},
+		[]string{"access_mode", "storage_class"},
+	)
+
+	// findLatency tracks how long FindBestMatchForClaim takes, labeled by access mode.
+	findLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "find_latency_seconds",
+			Help:      "Latency in seconds of FindBestMatchForClaim calls.",
+			Buckets:   metrics.DefBuckets,
+		},
+		[]string{"access_mode"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(volumeWastedBytes)
+	metrics.Registry.MustRegister(unmatchedClaimsTotal)
+	metrics.Registry.MustRegister(findLatency)
+}
+
+// accessModeLabel renders modes using the RWO/ROX/RWX/RWOP shorthand already used elsewhere
+// in the tree for metric labels, joining multiple modes with a comma.
+func accessModeLabel(modes []api.AccessModeType) string {
+	if len(modes) == 0 {
+		return "none"
+	}
+
+	labels := make([]string, len(modes))
+	for i, mode := range modes {
+		switch mode {
+		case api.ReadWriteOnce:
+			labels[i] = "RWO"
+		case api.ReadOnlyMany:
+			labels[i] = "ROX"
+		case api.ReadWriteMany:
+			labels[i] = "RWX"
+		case api.ReadWriteOncePod:
+			labels[i] = "RWOP"
+		default:
+			labels[i] = string(mode)
+		}
+	}
+	return strings.Join(labels, ",")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+func TestAccessModeLabel(t *testing.T) {
+	cases := []struct {
+		modes []api.AccessModeType
+		want  string
+	}{
+		{[]api.AccessModeType{api.ReadWriteOnce}, "RWO"},
+		{[]api.AccessModeType{api.ReadOnlyMany}, "ROX"},
+		{[]api.AccessModeType{api.ReadWriteMany}, "RWX"},
+		{[]api.AccessModeType{api.ReadWriteOncePod}, "RWOP"},
+		{[]api.AccessModeType{api.ReadWriteOnce, api.ReadOnlyMany}, "RWO,ROX"},
+		{nil, "none"},
+	}
+
+	for _, c := range cases {
+		if got := accessModeLabel(c.modes); got != c.want {
+			t.Errorf("accessModeLabel(%v) = %q, want %q", c.modes, got, c.want)
+		}
+	}
+}
+
+func TestFindBestMatchForClaimRecordsMetricsWithoutError(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(makeVolume("pv-10g", "10G", nil))
+
+	// An unmatched claim and a matched claim should both record metrics and return
+	// normally; FindBestMatchForClaim must not fail or panic because of instrumentation.
+	if _, err := index.FindBestMatchForClaim(makeClaim("50G", nil)); err != nil {
+		t.Fatalf("unexpected error for unmatched claim: %v", err)
+	}
+	match, err := index.FindBestMatchForClaim(makeClaim("5G", nil))
+	if err != nil {
+		t.Fatalf("unexpected error for matched claim: %v", err)
+	}
+	if match == nil || match.Name != "pv-10g" {
+		t.Errorf("expected pv-10g to match, got %v", match)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/apis/storage"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+)
+
+// Provisioner creates a new PersistentVolume on demand to satisfy a claim that the
+// persistentVolumeOrderedIndex could not match against any statically pre-provisioned volume.
+type Provisioner interface {
+	// Provision creates and returns a new, unbound PersistentVolume that satisfies claim
+	// according to class. The caller is responsible for binding the returned volume to claim,
+	// adding it to the index, and persisting it to the API server.
+	Provision(claim *api.PersistentVolumeClaim, class *storage.StorageClass) (*api.PersistentVolume, error)
+}
+
+// PersistentVolumeBinder matches claims against the PersistentVolume index, falling back to
+// dynamic provisioning when a claim's StorageClassName names a registered StorageClass but no
+// statically pre-provisioned volume satisfies it.
+type PersistentVolumeBinder struct {
+	volumes        *AssumeCache
+	storageClasses cache.Store
+	provisioner    Provisioner
+	podClaimRefs   *claimPodReferences
+}
+
+// NewPersistentVolumeBinder returns a PersistentVolumeBinder backed by volumes and
+// storageClasses. provisioner may be nil, in which case claims that can't be statically
+// matched are left unbound, as before.
+func NewPersistentVolumeBinder(volumes *persistentVolumeOrderedIndex, storageClasses cache.Store, provisioner Provisioner) *PersistentVolumeBinder {
+	return &PersistentVolumeBinder{
+		volumes:        NewAssumeCache(volumes),
+		storageClasses: storageClasses,
+		provisioner:    provisioner,
+		podClaimRefs:   newClaimPodReferences(),
+	}
+}
+
+// FindOrProvision returns the best matching PersistentVolume for claim and assumes it bound to
+// claim, so that a second, concurrent FindOrProvision call for another claim won't also match
+// it before this bind's API write round-trips back through the informer. If no statically
+// pre-provisioned volume satisfies claim and claim's StorageClassName refers to a registered
+// StorageClass, a new volume is provisioned and assumed bound to claim the same way.
+func (b *PersistentVolumeBinder) FindOrProvision(claim *api.PersistentVolumeClaim) (*api.PersistentVolume, error) {
+	match, err := b.volumes.FindBestMatchForClaim(claim)
+	if err != nil {
+		return nil, err
+	}
+	if match != nil {
+		bound := *match
+		bound.Spec.ClaimRef = claimRef(claim)
+		if err := b.volumes.Assume(&bound); err != nil {
+			return nil, err
+		}
+		return &bound, nil
+	}
+
+	if b.provisioner == nil || claim.Spec.StorageClassName == "" {
+		return nil, nil
+	}
+
+	obj, exists, err := b.storageClasses.GetByKey(claim.Spec.StorageClassName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	class, ok := obj.(*storage.StorageClass)
+	if !ok {
+		return nil, fmt.Errorf("object in storage class store is not a StorageClass: %v", obj)
+	}
+
+	pv, err := b.provisioner.Provision(claim, class)
+	if err != nil {
+		return nil, err
+	}
+
+	pv.Spec.ClaimRef = claimRef(claim)
+	if err := b.volumes.Assume(pv); err != nil {
+		return nil, err
+	}
+	return pv, nil
+}
+
+// claimRef builds the ObjectReference FindOrProvision stamps onto the PV it assumes bound to
+// claim.
+func claimRef(claim *api.PersistentVolumeClaim) *api.ObjectReference {
+	return &api.ObjectReference{
+		Kind:      "PersistentVolumeClaim",
+		Namespace: claim.Namespace,
+		Name:      claim.Name,
+	}
+}
+
+// BindPodToClaim enforces the ReadWriteOncePod guarantee at bind time: if claim's access modes
+// include ReadWriteOncePod, pod is recorded as the sole Pod allowed to use it, and the call
+// fails if a different Pod already holds that reservation. Claims that aren't ReadWriteOncePod
+// are unaffected.
+func (b *PersistentVolumeBinder) BindPodToClaim(pod *api.Pod, claim *api.PersistentVolumeClaim) error {
+	if !isReadWriteOncePod(claim) {
+		return nil
+	}
+
+	claimKey, err := cache.MetaNamespaceKeyFunc(claim)
+	if err != nil {
+		return err
+	}
+	podKey, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		return err
+	}
+	return b.podClaimRefs.Reserve(claimKey, podKey)
+}
+
+// ReleasePodClaim forgets claim's ReadWriteOncePod reservation, e.g. once the Pod referencing
+// it is deleted. It is a no-op for claims that aren't ReadWriteOncePod.
+func (b *PersistentVolumeBinder) ReleasePodClaim(claim *api.PersistentVolumeClaim) error {
+	if !isReadWriteOncePod(claim) {
+		return nil
+	}
+
+	claimKey, err := cache.MetaNamespaceKeyFunc(claim)
+	if err != nil {
+		return err
+	}
+	b.podClaimRefs.Release(claimKey)
+	return nil
+}
+
+// isReadWriteOncePod reports whether claim requests the ReadWriteOncePod access mode.
+func isReadWriteOncePod(claim *api.PersistentVolumeClaim) bool {
+	for _, mode := range claim.Spec.AccessModes {
+		if mode == api.ReadWriteOncePod {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/apis/storage"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+)
+
+func makeVolumeWithClass(name, capacity, storageClassName string) *api.PersistentVolume {
+	pv := makeVolume(name, capacity, nil)
+	pv.Spec.StorageClassName = storageClassName
+	return pv
+}
+
+func makeClaimWithClass(capacity, storageClassName string) *api.PersistentVolumeClaim {
+	claim := makeClaim(capacity, nil)
+	claim.Spec.StorageClassName = storageClassName
+	return claim
+}
+
+func TestFindBestMatchForClaimRejectsMismatchedStorageClass(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(makeVolumeWithClass("fast-10g", "10G", "fast-ssd"))
+
+	claim := makeClaimWithClass("5G", "slow-hdd")
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected no match across storage classes, got %v", match)
+	}
+}
+
+// fakeProvisioner records the claim it was asked to provision for and returns a synthesized
+// 5G PersistentVolume in the requested class, whatever size the claim asks for.
+type fakeProvisioner struct {
+	provisioned *api.PersistentVolumeClaim
+}
+
+func (f *fakeProvisioner) Provision(claim *api.PersistentVolumeClaim, class *storage.StorageClass) (*api.PersistentVolume, error) {
+	f.provisioned = claim
+	return makeVolumeWithClass("provisioned-pv", "5G", class.Name), nil
+}
+
+func TestFindOrProvisionProvisionsOnMiss(t *testing.T) {
+	storageClasses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	storageClasses.Add(&storage.StorageClass{ObjectMeta: api.ObjectMeta{Name: "fast-ssd"}})
+
+	provisioner := &fakeProvisioner{}
+	binder := NewPersistentVolumeBinder(NewPersistentVolumeOrderedIndex(), storageClasses, provisioner)
+
+	claim := makeClaimWithClass("5G", "fast-ssd")
+	claim.Namespace = "ns"
+	claim.Name = "claim-a"
+	pv, err := binder.FindOrProvision(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pv == nil || pv.Name != "provisioned-pv" {
+		t.Fatalf("expected a provisioned volume, got %v", pv)
+	}
+	if provisioner.provisioned != claim {
+		t.Errorf("expected provisioner to be invoked with the claim")
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Name != "claim-a" {
+		t.Errorf("expected the provisioned volume to be bound to the claim, got %+v", pv.Spec.ClaimRef)
+	}
+
+	// a second, unrelated claim must not also match the now-bound provisioned volume
+	second := makeClaimWithClass("5G", "fast-ssd")
+	second.Namespace = "ns"
+	second.Name = "claim-b"
+	match, err := binder.volumes.FindBestMatchForClaim(second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected the provisioned volume to already be bound, got %v", match)
+	}
+}
+
+func TestFindOrProvisionAssumesTheMatchSoASecondCallSkipsIt(t *testing.T) {
+	storageClasses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	binder := NewPersistentVolumeBinder(NewPersistentVolumeOrderedIndex(), storageClasses, nil)
+	binder.volumes.store.Add(makeVolume("pv-1", "10G", nil))
+
+	first := makeClaim("5G", nil)
+	match, err := binder.FindOrProvision(first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "pv-1" {
+		t.Fatalf("expected the first claim to match pv-1, got %v", match)
+	}
+
+	// Before pv-1's bind has round-tripped through the informer, a second claim must not also
+	// match it - that's the race AssumeCache exists to close.
+	second := makeClaim("5G", nil)
+	match, err = binder.FindOrProvision(second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected the second claim to find no match while pv-1 is assumed, got %v", match)
+	}
+}
+
+func TestBindPodToClaimEnforcesReadWriteOncePod(t *testing.T) {
+	storageClasses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	binder := NewPersistentVolumeBinder(NewPersistentVolumeOrderedIndex(), storageClasses, nil)
+
+	claim := makeClaim("5G", nil)
+	claim.Namespace = "ns"
+	claim.Name = "claim"
+	claim.Spec.AccessModes = []api.AccessModeType{api.ReadWriteOncePod}
+
+	podA := &api.Pod{ObjectMeta: api.ObjectMeta{Namespace: "ns", Name: "pod-a"}}
+	if err := binder.BindPodToClaim(podA, claim); err != nil {
+		t.Fatalf("unexpected error binding the first pod: %v", err)
+	}
+
+	podB := &api.Pod{ObjectMeta: api.ObjectMeta{Namespace: "ns", Name: "pod-b"}}
+	if err := binder.BindPodToClaim(podB, claim); err == nil {
+		t.Errorf("expected binding a second pod to a ReadWriteOncePod claim to fail")
+	}
+
+	if err := binder.ReleasePodClaim(claim); err != nil {
+		t.Fatalf("unexpected error releasing the claim: %v", err)
+	}
+	if err := binder.BindPodToClaim(podB, claim); err != nil {
+		t.Errorf("expected binding to succeed after release: %v", err)
+	}
+}
+
+func TestFindOrProvisionSkipsProvisioningForUnregisteredClass(t *testing.T) {
+	storageClasses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	provisioner := &fakeProvisioner{}
+	binder := NewPersistentVolumeBinder(NewPersistentVolumeOrderedIndex(), storageClasses, provisioner)
+
+	pv, err := binder.FindOrProvision(makeClaimWithClass("5G", "unregistered"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pv != nil {
+		t.Errorf("expected no volume for an unregistered storage class, got %v", pv)
+	}
+	if provisioner.provisioned != nil {
+		t.Errorf("expected the provisioner not to be invoked for an unregistered storage class")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/apis/storage"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+)
+
+// TestConcurrentFindAndMutate exercises FindBestMatchForClaim concurrently with Add/Update/
+// Delete on the same bucket. It exists to be run with `go test -race`: the bucket traversal
+// in Find/findBestMatchForClaim must hold pvIndex.mu across the whole scan, not just the
+// initial bucket lookup, or this test races against the informer-style mutations below.
+func TestConcurrentFindAndMutate(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	for i := 0; i < 50; i++ {
+		index.Add(makeVolume(pvNameForIndex(i), "10G", nil))
+	}
+
+	var wg sync.WaitGroup
+	claim := makeClaim("5G", nil)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := index.FindBestMatchForClaim(claim); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pv := makeVolume(pvNameForIndex(i), "10G", nil)
+			pv.Spec.ClaimRef = &api.ObjectReference{Name: "claim-x"}
+			index.Update(pv)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func pvNameForIndex(i int) string {
+	return "pv-" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+}
+
+// uniqueProvisioner hands out a distinct PV name per call, the way a real dynamic provisioner
+// (which mints unique names for every volume it creates) does.
+type uniqueProvisioner struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (p *uniqueProvisioner) Provision(claim *api.PersistentVolumeClaim, class *storage.StorageClass) (*api.PersistentVolume, error) {
+	p.mu.Lock()
+	name := fmt.Sprintf("provisioned-pv-%d", p.next)
+	p.next++
+	p.mu.Unlock()
+	return makeVolumeWithClass(name, "5G", class.Name), nil
+}
+
+// TestConcurrentFindOrProvisionDoesNotCrossAssignClaims runs many concurrent FindOrProvision
+// calls, all missing any static match and so falling to dynamic provisioning, for the same
+// storage class. It exists to be run with `go test -race`: FindOrProvision must assume each
+// provisioned PV bound to its own claim before returning, or a concurrent caller could
+// observe a freshly-added, still-unbound PV as its own static match.
+func TestConcurrentFindOrProvisionDoesNotCrossAssignClaims(t *testing.T) {
+	storageClasses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	storageClasses.Add(&storage.StorageClass{ObjectMeta: api.ObjectMeta{Name: "fast-ssd"}})
+
+	binder := NewPersistentVolumeBinder(NewPersistentVolumeOrderedIndex(), storageClasses, &uniqueProvisioner{})
+
+	const n = 20
+	claims := make([]*api.PersistentVolumeClaim, n)
+	for i := range claims {
+		claims[i] = makeClaimWithClass("5G", "fast-ssd")
+		claims[i].Namespace = "ns"
+		claims[i].Name = fmt.Sprintf("claim-%d", i)
+	}
+
+	results := make([]*api.PersistentVolume, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pv, err := binder.FindOrProvision(claims[i])
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			results[i] = pv
+		}(i)
+	}
+	wg.Wait()
+
+	boundTo := make(map[string]string) // pv name -> claim name it's bound to
+	for i, pv := range results {
+		if pv == nil {
+			t.Fatalf("expected claim %d to get a volume", i)
+		}
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Name != claims[i].Name {
+			t.Errorf("expected %s to be bound to %s, got %+v", pv.Name, claims[i].Name, pv.Spec.ClaimRef)
+		}
+		if owner, ok := boundTo[pv.Name]; ok && owner != claims[i].Name {
+			t.Errorf("%s was handed out to both %s and %s", pv.Name, owner, claims[i].Name)
+		}
+		boundTo[pv.Name] = claims[i].Name
+	}
+}
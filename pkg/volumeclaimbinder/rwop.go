@@ -0,0 +1,56 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"fmt"
+	"sync"
+)
+
+// claimPodReferences enforces the ReadWriteOncePod guarantee: once a bound RWOP claim is
+// referenced by a Pod, no other Pod may reference it until the first is released.
+type claimPodReferences struct {
+	mu      sync.Mutex
+	byClaim map[string]string // claim key -> the sole pod key allowed to use it
+}
+
+// newClaimPodReferences returns an empty claimPodReferences tracker.
+func newClaimPodReferences() *claimPodReferences {
+	return &claimPodReferences{
+		byClaim: make(map[string]string),
+	}
+}
+
+// Reserve records podKey as the Pod using claimKey. It fails if a different Pod already
+// holds the reservation, which the caller should surface as the Pod failing to start.
+func (r *claimPodReferences) Reserve(claimKey, podKey string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.byClaim[claimKey]; ok && existing != podKey {
+		return fmt.Errorf("claim %q uses ReadWriteOncePod and is already in use by pod %q", claimKey, existing)
+	}
+	r.byClaim[claimKey] = podKey
+	return nil
+}
+
+// Release forgets claimKey's reservation, e.g. once the referencing Pod is deleted.
+func (r *claimPodReferences) Release(claimKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byClaim, claimKey)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import "testing"
+
+func TestClaimPodReferencesRejectsSecondPod(t *testing.T) {
+	refs := newClaimPodReferences()
+
+	if err := refs.Reserve("ns/claim", "ns/pod-a"); err != nil {
+		t.Fatalf("unexpected error reserving for the first pod: %v", err)
+	}
+	if err := refs.Reserve("ns/claim", "ns/pod-a"); err != nil {
+		t.Errorf("expected re-reserving for the same pod to succeed, got: %v", err)
+	}
+	if err := refs.Reserve("ns/claim", "ns/pod-b"); err == nil {
+		t.Errorf("expected reserving a ReadWriteOncePod claim for a second pod to fail")
+	}
+}
+
+func TestClaimPodReferencesReleaseAllowsNewPod(t *testing.T) {
+	refs := newClaimPodReferences()
+
+	if err := refs.Reserve("ns/claim", "ns/pod-a"); err != nil {
+		t.Fatalf("unexpected error reserving for the first pod: %v", err)
+	}
+	refs.Release("ns/claim")
+
+	if err := refs.Reserve("ns/claim", "ns/pod-b"); err != nil {
+		t.Errorf("expected reservation to succeed after release, got: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"math/rand"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+const (
+	skipListMaxLevel    = 32
+	skipListProbability = 0.25
+)
+
+// capacitySkipList is a skip list, updated in place, holding one access-mode/volume-
+// mode/storage-class bucket's PersistentVolumes sorted by storage capacity. It supports
+// O(log N) Insert/Delete and an O(log N) Ceiling search (smallest capacity >= x), replacing
+// the sort.Sort call ListByAccessModes used to make on every invocation, which dominated
+// binder CPU and GC time once a cluster's PV count reached the thousands.
+type capacitySkipList struct {
+	head  *skipListNode
+	level int
+	size  int
+}
+
+type skipListNode struct {
+	capacity int64
+	pv       *api.PersistentVolume // nil only for the sentinel head node
+	forward  []*skipListNode
+}
+
+func newCapacitySkipList() *capacitySkipList {
+	return &capacitySkipList{
+		head:  &skipListNode{forward: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListProbability {
+		level++
+	}
+	return level
+}
+
+// nodeLess reports whether node's (capacity, name) key sorts strictly before (capacity, name).
+// Ties on capacity are broken by PV name so that volumes sharing a capacity still have a
+// total, stable order.
+func nodeLess(node *skipListNode, capacity int64, name string) bool {
+	if node.capacity != capacity {
+		return node.capacity < capacity
+	}
+	return node.pv.Name < name
+}
+
+// Insert adds pv to the skip list under the given capacity.
+func (s *capacitySkipList) Insert(capacity int64, pv *api.PersistentVolume) {
+	update := make([]*skipListNode, skipListMaxLevel)
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && nodeLess(node.forward[i], capacity, pv.Name) {
+			node = node.forward[i]
+		}
+		update[i] = node
+	}
+
+	level := randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	newNode := &skipListNode{capacity: capacity, pv: pv, forward: make([]*skipListNode, level)}
+	for i := 0; i < level; i++ {
+		newNode.forward[i] = update[i].forward[i]
+		update[i].forward[i] = newNode
+	}
+	s.size++
+}
+
+// Delete removes the volume named name previously inserted under capacity. It is a no-op if
+// no such entry exists.
+func (s *capacitySkipList) Delete(capacity int64, name string) {
+	update := make([]*skipListNode, skipListMaxLevel)
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && nodeLess(node.forward[i], capacity, name) {
+			node = node.forward[i]
+		}
+		update[i] = node
+	}
+
+	target := node.forward[0]
+	if target == nil || target.capacity != capacity || target.pv.Name != name {
+		return
+	}
+
+	for i := 0; i < s.level; i++ {
+		if update[i].forward[i] == target {
+			update[i].forward[i] = target.forward[i]
+		}
+	}
+	for s.level > 1 && s.head.forward[s.level-1] == nil {
+		s.level--
+	}
+	s.size--
+}
+
+// Len returns the number of volumes currently in the list.
+func (s *capacitySkipList) Len() int {
+	return s.size
+}
+
+// All returns every volume in the list in ascending capacity order. It's O(N); callers on a
+// hot path should prefer Ceiling.
+func (s *capacitySkipList) All() []*api.PersistentVolume {
+	volumes := make([]*api.PersistentVolume, 0, s.size)
+	for node := s.head.forward[0]; node != nil; node = node.forward[0] {
+		volumes = append(volumes, node.pv)
+	}
+	return volumes
+}
+
+// skipListCursor walks a capacitySkipList forward in ascending capacity order from some
+// starting point.
+type skipListCursor struct {
+	node *skipListNode
+}
+
+// Next returns the next volume under the cursor, or false once the list is exhausted.
+func (c *skipListCursor) Next() (*api.PersistentVolume, bool) {
+	if c.node == nil {
+		return nil, false
+	}
+	pv := c.node.pv
+	c.node = c.node.forward[0]
+	return pv, true
+}
+
+// Ceiling returns a cursor positioned at the first volume whose capacity is >= capacity -
+// the classic skip-list "ceiling" query, done in O(log N).
+func (s *capacitySkipList) Ceiling(capacity int64) *skipListCursor {
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && node.forward[i].capacity < capacity {
+			node = node.forward[i]
+		}
+	}
+	return &skipListCursor{node: node.forward[0]}
+}
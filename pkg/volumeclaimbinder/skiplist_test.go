@@ -0,0 +1,137 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import "testing"
+
+func TestCapacitySkipListOrdersByCapacity(t *testing.T) {
+	list := newCapacitySkipList()
+	list.Insert(30, makeVolume("c", "30G", nil))
+	list.Insert(10, makeVolume("a", "10G", nil))
+	list.Insert(20, makeVolume("b", "20G", nil))
+
+	got := list.All()
+	want := []string{"a", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d volumes, got %d", len(want), len(got))
+	}
+	for i, name := range want {
+		if got[i].Name != name {
+			t.Errorf("All()[%d] = %q, want %q", i, got[i].Name, name)
+		}
+	}
+}
+
+func TestCapacitySkipListCeiling(t *testing.T) {
+	list := newCapacitySkipList()
+	list.Insert(10, makeVolume("small", "10G", nil))
+	list.Insert(30, makeVolume("large", "30G", nil))
+
+	cursor := list.Ceiling(20)
+	pv, ok := cursor.Next()
+	if !ok || pv.Name != "large" {
+		t.Fatalf("expected Ceiling(20) to find large, got %v, ok=%v", pv, ok)
+	}
+	if _, ok := cursor.Next(); ok {
+		t.Errorf("expected the cursor to be exhausted after the only >=20 entry")
+	}
+
+	if cursor := list.Ceiling(100); func() bool { _, ok := cursor.Next(); return ok }() {
+		t.Errorf("expected Ceiling(100) to find nothing")
+	}
+}
+
+func TestCapacitySkipListDelete(t *testing.T) {
+	list := newCapacitySkipList()
+	list.Insert(10, makeVolume("a", "10G", nil))
+	list.Insert(10, makeVolume("b", "10G", nil))
+
+	list.Delete(10, "a")
+
+	if list.Len() != 1 {
+		t.Fatalf("expected 1 entry after deleting one of two same-capacity entries, got %d", list.Len())
+	}
+	got := list.All()
+	if got[0].Name != "b" {
+		t.Errorf("expected the remaining entry to be b, got %q", got[0].Name)
+	}
+
+	// deleting something absent is a no-op
+	list.Delete(999, "nonexistent")
+	if list.Len() != 1 {
+		t.Errorf("expected deleting a nonexistent entry not to change the list, got len %d", list.Len())
+	}
+}
+
+func TestPersistentVolumeOrderedIndexUpdateMovesBetweenBuckets(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolumeWithClass("pv-1", "10G", "fast-ssd")
+	index.Add(pv)
+
+	moved := *pv
+	moved.Spec.StorageClassName = "slow-hdd"
+	index.Update(&moved)
+
+	match, err := index.FindBestMatchForClaim(makeClaimWithClass("5G", "fast-ssd"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected no volume left in the fast-ssd bucket after the update, got %v", match)
+	}
+
+	match, err = index.FindBestMatchForClaim(makeClaimWithClass("5G", "slow-hdd"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "pv-1" {
+		t.Errorf("expected pv-1 to be found in the slow-hdd bucket after the update, got %v", match)
+	}
+}
+
+func TestPersistentVolumeOrderedIndexAddTwiceForSameKeyLeavesOneBucketEntry(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	index.Add(pv)
+
+	// A reflector can replay an Added event for a key it already holds; re-Adding the same
+	// key must not leave a stale duplicate node behind in the capacity bucket.
+	index.Add(pv)
+
+	bucketKey, err := bucketKeyFor(pv.Spec.AccessModes, pv.Spec.VolumeMode, pv.Spec.StorageClassName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := index.buckets[bucketKey].Len(); got != 1 {
+		t.Errorf("expected exactly 1 bucket entry after Add is called twice for the same key, got %d", got)
+	}
+}
+
+func TestPersistentVolumeOrderedIndexDeleteRemovesFromBucket(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	pv := makeVolume("pv-1", "10G", nil)
+	index.Add(pv)
+	index.Delete(pv)
+
+	match, err := index.FindBestMatchForClaim(makeClaim("5G", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected no match after deleting the only volume, got %v", match)
+	}
+}
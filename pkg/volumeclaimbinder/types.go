@@ -19,79 +19,240 @@ package volumeclaimbinder
 import (
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/resource"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/unversioned"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
-	"github.com/GoogleCloudPlatform/kubernetes/pkg/volume"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/metrics"
 )
 
-// persistentVolumeOrderedIndex is a cache.Store that keeps persistent volumes indexed by AccessModes and ordered by storage capacity.
+// persistentVolumeOrderedIndex is a cache.Store that keeps persistent volumes indexed by
+// AccessModes, VolumeMode and StorageClassName, and ordered within each bucket by storage
+// capacity via a capacitySkipList so Find doesn't have to resort the bucket on every call.
 type persistentVolumeOrderedIndex struct {
 	cache.Indexer
+
+	mu      sync.RWMutex
+	buckets map[string]*capacitySkipList
 }
 
 var _ cache.Store = &persistentVolumeOrderedIndex{} // persistentVolumeOrderedIndex is a Store
 
 func NewPersistentVolumeOrderedIndex() *persistentVolumeOrderedIndex {
 	return &persistentVolumeOrderedIndex{
-		cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{"accessmodes": accessModesIndexFunc}),
+		Indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{"accessmodes": accessModesIndexFunc}),
+		buckets: make(map[string]*capacitySkipList),
+	}
+}
+
+// Add inserts pv into the store and its capacity bucket. Like cache.Store.Add on any other
+// Indexer, Add is safe to call again for a key that's already present (e.g. a reflector
+// replaying an Added event it has already processed); any existing bucket entry for pv's key
+// is removed first so the bucket never ends up with two nodes for the same PV.
+func (pvIndex *persistentVolumeOrderedIndex) Add(obj interface{}) error {
+	pv, ok := obj.(*api.PersistentVolume)
+	if !ok {
+		return fmt.Errorf("object is not a persistent volume: %v", obj)
+	}
+	pvIndex.removeFromBucketIfPresent(pv)
+	if err := pvIndex.Indexer.Add(pv); err != nil {
+		return err
+	}
+	pvIndex.insertIntoBucket(pv)
+	return nil
+}
+
+// Update replaces pv in the store, moving it to its (possibly new) capacity bucket.
+func (pvIndex *persistentVolumeOrderedIndex) Update(obj interface{}) error {
+	pv, ok := obj.(*api.PersistentVolume)
+	if !ok {
+		return fmt.Errorf("object is not a persistent volume: %v", obj)
+	}
+	pvIndex.removeFromBucketIfPresent(pv)
+	if err := pvIndex.Indexer.Update(pv); err != nil {
+		return err
+	}
+	pvIndex.insertIntoBucket(pv)
+	return nil
+}
+
+// Delete removes pv from the store and its capacity bucket.
+func (pvIndex *persistentVolumeOrderedIndex) Delete(obj interface{}) error {
+	pv, ok := obj.(*api.PersistentVolume)
+	if !ok {
+		return fmt.Errorf("object is not a persistent volume: %v", obj)
+	}
+	pvIndex.removeFromBucketIfPresent(pv)
+	return pvIndex.Indexer.Delete(pv)
+}
+
+// insertIntoBucket adds pv to the capacitySkipList for its (accessModes, volumeMode,
+// storageClass) bucket, creating the bucket if this is its first volume.
+func (pvIndex *persistentVolumeOrderedIndex) insertIntoBucket(pv *api.PersistentVolume) {
+	bucketKey, err := accessModesIndexFunc(pv)
+	if err != nil {
+		return
+	}
+
+	pvIndex.mu.Lock()
+	defer pvIndex.mu.Unlock()
+
+	bucket, ok := pvIndex.buckets[bucketKey]
+	if !ok {
+		bucket = newCapacitySkipList()
+		pvIndex.buckets[bucketKey] = bucket
+	}
+	bucket.Insert(pvCapacity(pv), pv)
+}
+
+// removeFromBucketIfPresent removes whatever is currently stored under pv's key from its
+// bucket, using the previously stored object's bucket key and capacity rather than pv's own
+// (which may have changed), so a mutation that moves pv between buckets doesn't leave a stale
+// entry behind.
+func (pvIndex *persistentVolumeOrderedIndex) removeFromBucketIfPresent(pv *api.PersistentVolume) {
+	key, err := cache.MetaNamespaceKeyFunc(pv)
+	if err != nil {
+		return
+	}
+
+	existing, exists, err := pvIndex.Indexer.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	existingPV := existing.(*api.PersistentVolume)
+
+	bucketKey, err := accessModesIndexFunc(existingPV)
+	if err != nil {
+		return
+	}
+
+	pvIndex.mu.Lock()
+	defer pvIndex.mu.Unlock()
+
+	if bucket, ok := pvIndex.buckets[bucketKey]; ok {
+		bucket.Delete(pvCapacity(existingPV), existingPV.Name)
+	}
+}
+
+// pvCapacity returns a PersistentVolume's storage capacity in bytes, the key capacitySkipList
+// buckets are ordered by.
+func pvCapacity(pv *api.PersistentVolume) int64 {
+	qty := pv.Spec.Capacity[api.ResourceStorage]
+	return qty.Value()
+}
+
+// getVolumeModeOrDefault returns the given VolumeMode, defaulting to Filesystem (the
+// Kubernetes convention for volumes that predate VolumeMode) when nil.
+func getVolumeModeOrDefault(volumeMode *api.PersistentVolumeMode) api.PersistentVolumeMode {
+	if volumeMode != nil {
+		return *volumeMode
 	}
+	return api.PersistentVolumeFilesystem
 }
 
-// accessModesIndexFunc is an indexing function that returns a persistent volume's AccessModes as a string
+// accessModesIndexKey builds the composite bucket key from a set of AccessModeTypes. Modes
+// are sorted and joined directly by name rather than via the human-readable "RWO/ROX/RWX"
+// rendering used for display, so that ReadWriteOncePod gets its own bucket instead of being
+// folded into ReadWriteOnce.
+func accessModesIndexKey(modes []api.AccessModeType) string {
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = string(mode)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// accessModesIndexFunc is an indexing function that returns a persistent volume's AccessModes,
+// VolumeMode and StorageClassName as a composite string key
 func accessModesIndexFunc(obj interface{}) (string, error) {
 	if pv, ok := obj.(*api.PersistentVolume); ok {
-		modes := volume.GetAccessModesAsString(pv.Spec.AccessModes)
-		return modes, nil
+		modes := accessModesIndexKey(pv.Spec.AccessModes)
+		return fmt.Sprintf("%s/%s/%s", modes, getVolumeModeOrDefault(pv.Spec.VolumeMode), pv.Spec.StorageClassName), nil
 	}
 	return "", fmt.Errorf("object is not a persistent volume: %v", obj)
 }
 
-// ListByAccessModes returns all volumes with the given set of AccessModeTypes *in order* of their storage capacity (low to high)
-func (pvIndex *persistentVolumeOrderedIndex) ListByAccessModes(modes []api.AccessModeType) ([]*api.PersistentVolume, error) {
-	pv := &api.PersistentVolume{
-		Spec: api.PersistentVolumeSpec{
-			AccessModes: modes,
-		},
-	}
-
-	objs, err := pvIndex.Index("accessmodes", pv)
+// ListByAccessModes returns all volumes with the given set of AccessModeTypes, VolumeMode and
+// StorageClassName *in order* of their storage capacity (low to high). The bucket is already
+// capacity-ordered by its capacitySkipList, so this is a single O(N) walk with no sorting.
+func (pvIndex *persistentVolumeOrderedIndex) ListByAccessModes(modes []api.AccessModeType, volumeMode *api.PersistentVolumeMode, storageClassName string) ([]*api.PersistentVolume, error) {
+	bucketKey, err := bucketKeyFor(modes, volumeMode, storageClassName)
 	if err != nil {
 		return nil, err
 	}
 
-	volumes := make([]*api.PersistentVolume, len(objs))
-	for i, obj := range objs {
-		volumes[i] = obj.(*api.PersistentVolume)
+	pvIndex.mu.RLock()
+	defer pvIndex.mu.RUnlock()
+
+	bucket, ok := pvIndex.buckets[bucketKey]
+	if !ok {
+		return nil, nil
 	}
+	return bucket.All(), nil
+}
 
-	sort.Sort(byCapacity{volumes})
-	return volumes, nil
+// bucketKeyFor computes the composite bucket key for a set of bucket coordinates. It does not
+// touch pvIndex.buckets, so callers are free to use it without holding pvIndex.mu.
+func bucketKeyFor(modes []api.AccessModeType, volumeMode *api.PersistentVolumeMode, storageClassName string) (string, error) {
+	return accessModesIndexFunc(&api.PersistentVolume{
+		Spec: api.PersistentVolumeSpec{
+			AccessModes:      modes,
+			VolumeMode:       volumeMode,
+			StorageClassName: storageClassName,
+		},
+	})
 }
 
 // matchPredicate is a function that indicates that a persistent volume matches another
 type matchPredicate func(compareThis, toThis *api.PersistentVolume) bool
 
-// Find returns the nearest PV from the ordered list or nil if a match is not found
+// Find returns the smallest-capacity PV satisfying matchPredicate, or nil if none does. It
+// performs an O(log N) ceiling search for pv's requested capacity within the matching bucket,
+// followed by a short linear scan forward to skip over bound or otherwise mismatched PVs -
+// cheaper than resorting and binary-searching the whole bucket on every call. pvIndex.mu is
+// held (for reading) across the whole traversal, not just the bucket lookup, since Add/Update/
+// Delete mutate a bucket's skip-list node pointers in place; releasing the lock before walking
+// the cursor would race with a concurrent mutation (e.g. the informer reflector binding this
+// same PV while the binder's own goroutine is still scanning it).
 func (pvIndex *persistentVolumeOrderedIndex) Find(pv *api.PersistentVolume, matchPredicate matchPredicate) (*api.PersistentVolume, error) {
-	volumes, err := pvIndex.ListByAccessModes(pv.Spec.AccessModes)
+	bucketKey, err := bucketKeyFor(pv.Spec.AccessModes, pv.Spec.VolumeMode, pv.Spec.StorageClassName)
 	if err != nil {
 		return nil, err
 	}
 
-	i := sort.Search(len(volumes), func(i int) bool { return matchPredicate(pv, volumes[i]) })
-	if i < len(volumes) {
-		return volumes[i], nil
+	pvIndex.mu.RLock()
+	defer pvIndex.mu.RUnlock()
+
+	bucket, ok := pvIndex.buckets[bucketKey]
+	if !ok {
+		return nil, nil
+	}
+
+	requested := pv.Spec.Capacity[api.ResourceStorage]
+	cursor := bucket.Ceiling(requested.Value())
+	for {
+		candidate, ok := cursor.Next()
+		if !ok {
+			return nil, nil
+		}
+		if matchPredicate(pv, candidate) {
+			return candidate, nil
+		}
 	}
-	return nil, nil
 }
 
 // FindByAccessModesAndStorageCapacity is a convenience method that calls Find w/ requisite matchPredicate for storage
-func (pvIndex *persistentVolumeOrderedIndex) FindByAccessModesAndStorageCapacity(modes []api.AccessModeType, qty resource.Quantity) (*api.PersistentVolume, error) {
+func (pvIndex *persistentVolumeOrderedIndex) FindByAccessModesAndStorageCapacity(modes []api.AccessModeType, volumeMode *api.PersistentVolumeMode, storageClassName string, qty resource.Quantity) (*api.PersistentVolume, error) {
 	pv := &api.PersistentVolume{
 		Spec: api.PersistentVolumeSpec{
-			AccessModes: modes,
+			AccessModes:      modes,
+			VolumeMode:       volumeMode,
+			StorageClassName: storageClassName,
 			Capacity: api.ResourceList{
 				api.ResourceName(api.ResourceStorage): qty,
 			},
@@ -101,26 +262,89 @@ func (pvIndex *persistentVolumeOrderedIndex) FindByAccessModesAndStorageCapacity
 	return pvIndex.Find(pv, filterBoundVolumes)
 }
 
-// FindBestMatchForClaim is a convenience method that finds a volume by the claim's AccessModes and requests for Storage
+// FindBestMatchForClaim is a convenience method that finds a volume by the claim's AccessModes,
+// VolumeMode, StorageClassName, label Selector and requests for Storage. A claim with an empty
+// StorageClassName only matches volumes that likewise have no StorageClassName. If the claim
+// sets a Selector, only volumes whose labels satisfy it are considered.
+//
+// Match quality and latency are recorded to the package's Prometheus metrics; see metrics.go.
 func (pvIndex *persistentVolumeOrderedIndex) FindBestMatchForClaim(claim *api.PersistentVolumeClaim) (*api.PersistentVolume, error) {
-	return pvIndex.FindByAccessModesAndStorageCapacity(claim.Spec.AccessModes, claim.Spec.Resources.Requests[api.ResourceName(api.ResourceStorage)])
-}
+	modeLabel := accessModeLabel(claim.Spec.AccessModes)
+	timer := metrics.NewTimer(findLatency.WithLabelValues(modeLabel))
+	defer timer.ObserveDuration()
 
-// byCapacity is used to order volumes by ascending storage size
-type byCapacity struct {
-	volumes []*api.PersistentVolume
-}
+	match, err := pvIndex.findBestMatchForClaim(claim)
+	if err != nil {
+		return nil, err
+	}
 
-func (c byCapacity) Less(i, j int) bool {
-	return matchStorageCapacity(c.volumes[i], c.volumes[j])
-}
+	if match == nil {
+		unmatchedClaimsTotal.WithLabelValues(modeLabel, claim.Spec.StorageClassName).Inc()
+		return nil, nil
+	}
 
-func (c byCapacity) Swap(i, j int) {
-	c.volumes[i], c.volumes[j] = c.volumes[j], c.volumes[i]
+	requested := claim.Spec.Resources.Requests[api.ResourceName(api.ResourceStorage)]
+	capacity := match.Spec.Capacity[api.ResourceStorage]
+	if wasted := capacity.Value() - requested.Value(); wasted > 0 {
+		volumeWastedBytes.WithLabelValues(modeLabel).Observe(float64(wasted))
+	}
+	return match, nil
 }
 
-func (c byCapacity) Len() int {
-	return len(c.volumes)
+// findBestMatchForClaim contains the actual matching logic for FindBestMatchForClaim, kept
+// separate so that method can focus on recording metrics around it. Like Find, it performs an
+// O(log N) ceiling search followed by a short scan forward, additionally skipping volumes
+// that don't satisfy the claim's label Selector, if one is set. pvIndex.mu is held across the
+// whole traversal for the same reason as in Find: releasing it before walking the cursor would
+// race with a concurrent Add/Update/Delete mutating the same bucket's skip-list nodes.
+func (pvIndex *persistentVolumeOrderedIndex) findBestMatchForClaim(claim *api.PersistentVolumeClaim) (*api.PersistentVolume, error) {
+	bucketKey, err := bucketKeyFor(claim.Spec.AccessModes, claim.Spec.VolumeMode, claim.Spec.StorageClassName)
+	if err != nil {
+		return nil, err
+	}
+
+	var selector labels.Selector
+	if claim.Spec.Selector != nil {
+		selector, err = unversioned.LabelSelectorAsSelector(claim.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pv := &api.PersistentVolume{
+		Spec: api.PersistentVolumeSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			VolumeMode:       claim.Spec.VolumeMode,
+			StorageClassName: claim.Spec.StorageClassName,
+			Capacity: api.ResourceList{
+				api.ResourceName(api.ResourceStorage): claim.Spec.Resources.Requests[api.ResourceName(api.ResourceStorage)],
+			},
+		},
+	}
+
+	pvIndex.mu.RLock()
+	defer pvIndex.mu.RUnlock()
+
+	bucket, ok := pvIndex.buckets[bucketKey]
+	if !ok {
+		return nil, nil
+	}
+
+	requested := pv.Spec.Capacity[api.ResourceStorage]
+	cursor := bucket.Ceiling(requested.Value())
+	for {
+		candidate, ok := cursor.Next()
+		if !ok {
+			return nil, nil
+		}
+		if !filterBoundVolumes(pv, candidate) {
+			continue
+		}
+		if selector != nil && !selector.Matches(labels.Set(candidate.ObjectMeta.Labels)) {
+			continue
+		}
+		return candidate, nil
+	}
 }
 
 // matchStorageCapacity is a matchPredicate used to sort and find volumes
@@ -137,10 +361,17 @@ func matchStorageCapacity(pvA, pvB *api.PersistentVolume) bool {
 	return aSize <= bSize
 }
 
-// filterBoundVolumes is a matchPredicate that filters bound volumes before comparing storage capacity
+// filterBoundVolumes is a matchPredicate that filters bound volumes and volumes whose
+// VolumeMode or StorageClassName doesn't match before comparing storage capacity
 func filterBoundVolumes(compareThis, toThis *api.PersistentVolume) bool {
 	if compareThis.Spec.ClaimRef != nil || toThis.Spec.ClaimRef != nil {
 		return false
 	}
+	if getVolumeModeOrDefault(compareThis.Spec.VolumeMode) != getVolumeModeOrDefault(toThis.Spec.VolumeMode) {
+		return false
+	}
+	if compareThis.Spec.StorageClassName != toThis.Spec.StorageClassName {
+		return false
+	}
 	return matchStorageCapacity(compareThis, toThis)
 }
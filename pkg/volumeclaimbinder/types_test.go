@@ -0,0 +1,152 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumeclaimbinder
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/resource"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/unversioned"
+)
+
+func blockMode() *api.PersistentVolumeMode {
+	mode := api.PersistentVolumeBlock
+	return &mode
+}
+
+func filesystemMode() *api.PersistentVolumeMode {
+	mode := api.PersistentVolumeFilesystem
+	return &mode
+}
+
+func makeVolume(name, capacity string, volumeMode *api.PersistentVolumeMode) *api.PersistentVolume {
+	return &api.PersistentVolume{
+		ObjectMeta: api.ObjectMeta{Name: name},
+		Spec: api.PersistentVolumeSpec{
+			AccessModes: []api.AccessModeType{api.ReadWriteOnce},
+			VolumeMode:  volumeMode,
+			Capacity: api.ResourceList{
+				api.ResourceStorage: resource.MustParse(capacity),
+			},
+		},
+	}
+}
+
+func makeClaim(capacity string, volumeMode *api.PersistentVolumeMode) *api.PersistentVolumeClaim {
+	return &api.PersistentVolumeClaim{
+		Spec: api.PersistentVolumeClaimSpec{
+			AccessModes: []api.AccessModeType{api.ReadWriteOnce},
+			VolumeMode:  volumeMode,
+			Resources: api.ResourceRequirements{
+				Requests: api.ResourceList{
+					api.ResourceStorage: resource.MustParse(capacity),
+				},
+			},
+		},
+	}
+}
+
+func TestFindBestMatchForClaimRespectsVolumeMode(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(makeVolume("block-10g", "10G", blockMode()))
+	index.Add(makeVolume("fs-10g", "10G", filesystemMode()))
+
+	claim := makeClaim("5G", blockMode())
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "block-10g" {
+		t.Errorf("expected block-10g to match a Block claim, got %v", match)
+	}
+}
+
+func TestFindBestMatchForClaimDefaultsNilVolumeModeToFilesystem(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(makeVolume("fs-10g", "10G", nil))
+
+	claim := makeClaim("5G", filesystemMode())
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "fs-10g" {
+		t.Errorf("expected a nil VolumeMode volume to be treated as Filesystem, got %v", match)
+	}
+}
+
+func TestFindBestMatchForClaimRejectsMismatchedVolumeMode(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(makeVolume("block-10g", "10G", blockMode()))
+
+	claim := makeClaim("5G", filesystemMode())
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected no match for a Filesystem claim against a Block-only pool, got %v", match)
+	}
+}
+
+func TestFindBestMatchForClaimTreatsReadWriteOncePodAsDistinctBucket(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	index.Add(&api.PersistentVolume{
+		ObjectMeta: api.ObjectMeta{Name: "rwo-10g"},
+		Spec: api.PersistentVolumeSpec{
+			AccessModes: []api.AccessModeType{api.ReadWriteOnce},
+			Capacity:    api.ResourceList{api.ResourceStorage: resource.MustParse("10G")},
+		},
+	})
+
+	claim := &api.PersistentVolumeClaim{
+		Spec: api.PersistentVolumeClaimSpec{
+			AccessModes: []api.AccessModeType{api.ReadWriteOncePod},
+			Resources: api.ResourceRequirements{
+				Requests: api.ResourceList{api.ResourceStorage: resource.MustParse("5G")},
+			},
+		},
+	}
+
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match != nil {
+		t.Errorf("expected a ReadWriteOncePod claim not to match a ReadWriteOnce-only volume, got %v", match)
+	}
+}
+
+func TestFindBestMatchForClaimHonorsSelector(t *testing.T) {
+	index := NewPersistentVolumeOrderedIndex()
+	reserved := makeVolume("reserved-10g", "10G", nil)
+	reserved.ObjectMeta.Labels = map[string]string{"pool": "reserved"}
+	index.Add(reserved)
+	index.Add(makeVolume("unreserved-10g", "10G", nil))
+
+	claim := makeClaim("5G", nil)
+	claim.Spec.Selector = &unversioned.LabelSelector{MatchLabels: map[string]string{"pool": "reserved"}}
+
+	match, err := index.FindBestMatchForClaim(claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match == nil || match.Name != "reserved-10g" {
+		t.Errorf("expected the claim's selector to restrict the match to reserved-10g, got %v", match)
+	}
+}